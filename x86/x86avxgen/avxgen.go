@@ -0,0 +1,730 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// x86avxgen generates Go assembler tables (ytabs/optabs) for the VEX/EVEX
+// encoded AVX/AVX2/AVX-512 instruction set by reading the Intel XED
+// instruction database.
+//
+// Usage:
+//
+//	x86avxgen -xedpath=<path to XED datafiles> [flags] > table.go
+//
+// NOTE: this file and avxgen_test.go depend on golang.org/x/arch/x86/xeddata
+// (xeddata.Database, xeddata.Inst and the Inst.GoName/PrefixBytes/ModRMForm/
+// VectorLength/MemoryElementSize/HasAttribute/BroadcastFactor/OpcodeBytes/
+// VexMMMMM/VexPP/EvexW/IForm/ISASet/EncodingClass accessors used below),
+// plus testdata/xedpath and testdata/golden.txt fixtures. None of that is
+// present in this checkout, so this package has not been built or tested
+// here; treat it as a patch against a tree that has the real xeddata
+// package and fixtures checked in, not as verified-working code. Keep
+// this list current: every Inst accessor this file adds belongs here,
+// and every test in avxgen_test.go that depends on xeddata/testdata
+// belongs in that file's matching header note.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/arch/x86/xeddata"
+)
+
+// amdOpcodes lists VEX-encoded instructions that are AMD-only
+// (FMA4, XOP/VPERMIL2) and are therefore excluded from the default,
+// Intel-focused table output.
+var amdOpcodes = makeStringSet(
+	"VFMADDPD",
+	"VFMADDPS",
+	"VFMADDSD",
+	"VFMADDSS",
+	"VFMADDSUBPD",
+	"VFMADDSUBPS",
+	"VFMSUBADDPD",
+	"VFMSUBADDPS",
+	"VFMSUBPD",
+	"VFMSUBPS",
+	"VFMSUBSD",
+	"VFMSUBSS",
+	"VFNMADDPD",
+	"VFNMADDPS",
+	"VFNMADDSD",
+	"VFNMADDSS",
+	"VFNMSUBPD",
+	"VFNMSUBPS",
+	"VFNMSUBSD",
+	"VFNMSUBSS",
+	"VPERMIL2PD",
+	"VPERMIL2PS",
+)
+
+func makeStringSet(keys ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+// context holds the state threaded through buildTables and writeTables.
+type context struct {
+	xedPath string
+	db      *xeddata.Database
+
+	// includeAMD, when set, causes buildTables to emit the AMD-only
+	// FMA4/XOP encodings listed in amdOpcodes instead of dropping them.
+	includeAMD bool
+
+	// modeSet is the set of processor modes (m16|m32|m64) buildTables
+	// generates ytab rows for. Defaults to m32|m64 for backward
+	// compatibility; passing m16 additionally emits 16-bit
+	// operand/address-size rows for the VEX-encodable subset.
+	modeSet uint8
+
+	// optabs maps an opcode to its (possibly several) operand-width
+	// variants. buildTables emits one Optab per XMM/YMM/ZMM width;
+	// foldTables may later collapse several of them into one.
+	optabs map[string][]*Optab
+	ytabs  []*Ytab
+}
+
+// Width classes an EVEX-encoded operand can take, carried as a bitmask
+// in Optab.Width so that foldTables can widen a row to cover more than
+// one of them.
+const (
+	szX uint8 = 1 << iota // 128-bit XMM
+	szY                   // 256-bit YMM
+	szZ                   // 512-bit ZMM
+)
+
+// Processor modes buildTables can generate ytab rows for, carried as a
+// bitmask in context.modeSet.
+const (
+	m16 uint8 = 1 << iota // 16-bit operand/address size (real mode, 16-bit code segments)
+	m32
+	m64
+
+	defaultModeSet = m32 | m64
+)
+
+// Optab is a single opcode's assembler table entry: the Go opcode name,
+// its operand-shape (ytab) index and the encoding bytes needed to emit it.
+type Optab struct {
+	Opcode  string
+	Ytab    *Ytab
+	Prefix  []byte
+	ModRM   string
+	ImmKind string
+
+	// Encoding is the instruction's opcode byte(s), e.g. {0x58} for
+	// VADDPS. VexMMMMM/VexPP/EvexW are the corresponding VEX/EVEX
+	// prefix fields; EvexW is only meaningful when the row has an EVEX
+	// prefix (VexMMMMM/VexPP nonempty and Evex non-empty or this being
+	// an AVX-512 opcode). Together with Prefix and ModRM these are what
+	// -format=json needs to reconstruct the encoding without
+	// re-parsing XED.
+	Encoding []byte
+	VexMMMMM string
+	VexPP    string
+	EvexW    uint8
+
+	// EncodingClass is "VEX" or "EVEX", read directly from XED's own
+	// ENCODING attribute rather than inferred from whether Evex carries
+	// any decorator flags: an EVEX-encoded instruction whose form
+	// happens to support no rounding/SAE/broadcast/mask/zeroing at all
+	// still has Evex.empty() == true, and must not be misclassified VEX.
+	EncodingClass string
+
+	// CPUID is the XED ISA_SET this opcode belongs to (e.g. "AVX2",
+	// "AVX512F"), used by the JSON schema's cpuid field so downstream
+	// tools can gate on CPU support without re-parsing XED.
+	CPUID string
+
+	// Width is the set of vector widths (szX|szY|szZ) this row covers.
+	// buildTables always sets exactly one bit; foldTables may OR several
+	// rows together when it merges size variants.
+	Width uint8
+
+	// MemWidth is the width class of this row's memory operand, or 0 if
+	// the form has no memory operand. It differs from Width for
+	// instructions whose memory operand is narrower than its register
+	// operand (broadcast, vpmov* down-converts, vbroadcast* up-converts):
+	// foldTables must not merge those rows together, since the folded
+	// ytab would then lie about the memory operand's actual size.
+	MemWidth uint8
+
+	// AMD marks an entry sourced from amdOpcodes. Consumers in
+	// cmd/internal/obj/x86 use this to gate the encoding behind a
+	// CPU-feature check rather than treating it as Intel-standard.
+	AMD bool
+
+	// Evex carries the EVEX decorator metadata (embedded rounding, SAE,
+	// broadcast, mask/zero) this row's operands accept, read from XED's
+	// per-instruction attributes. obj/x86 uses it to validate decorator
+	// syntax at assemble time instead of silently accepting e.g. {1to8}
+	// on a scalar instruction.
+	Evex EvexFlags
+
+	// IForms lists the XED IFORM string(s) (e.g. "VADDPD_XMM",
+	// "VADDPD_YMM", "VADDPD_ZMM") this row was built from. buildTables
+	// always sets exactly one; foldTables appends the rest when it
+	// merges several width variants into one row. readTimings joins
+	// against these, not against Opcode, because XED's perf tables are
+	// keyed by IFORM and a bare opcode can't tell two different widths'
+	// costs apart.
+	IForms []string
+}
+
+// EvexFlags is the per-row decorator metadata emitted alongside the
+// optab/ytab tables: which EVEX decorators, if any, this encoding's
+// operands accept.
+type EvexFlags struct {
+	ER      bool // embedded rounding ({rn-sae} et al.) on this form
+	SAE     bool // suppress-all-exceptions ({sae})
+	Bcast4  bool // {1to4} broadcast
+	Bcast8  bool // {1to8} broadcast
+	Bcast16 bool // {1to16} broadcast
+	K       bool // accepts a {k1} writemask
+	Z       bool // accepts {z} zeroing
+}
+
+// empty reports whether no decorator is set, so writeTables can skip
+// emitting a row of all-false flags.
+func (f EvexFlags) empty() bool {
+	return f == EvexFlags{}
+}
+
+// mergeEvexFlags ORs two rows' decorator flags together. Decorators are
+// inherently per-width (a {1to4} broadcast only applies to the XMM form
+// of an instruction, {1to16} only to its ZMM form, and embedded
+// rounding/SAE typically only apply to the full ZMM register-register
+// form), so when foldTables widens several rows into one, the folded
+// row must still report every decorator any of its constituent widths
+// accepted rather than keeping only one width's flags.
+func mergeEvexFlags(a, b EvexFlags) EvexFlags {
+	return EvexFlags{
+		ER:      a.ER || b.ER,
+		SAE:     a.SAE || b.SAE,
+		Bcast4:  a.Bcast4 || b.Bcast4,
+		Bcast8:  a.Bcast8 || b.Bcast8,
+		Bcast16: a.Bcast16 || b.Bcast16,
+		K:       a.K || b.K,
+		Z:       a.Z || b.Z,
+	}
+}
+
+// key groups Optabs that are candidates for folding: same opcode,
+// prefix bytes, ModR/M form and immediate kind. Rows in the same group
+// differ only in operand width.
+func (o *Optab) key() string {
+	return fmt.Sprintf("%s\x00%x\x00%s\x00%s", o.Opcode, o.Prefix, o.ModRM, o.ImmKind)
+}
+
+// Ytab describes the operand width/class combination an Optab accepts.
+type Ytab struct {
+	Name  string
+	Zcase uint8
+	Args  []uint8
+}
+
+func newContext(xedPath string, includeAMD bool) *context {
+	return &context{
+		xedPath:    xedPath,
+		includeAMD: includeAMD,
+		modeSet:    defaultModeSet,
+		optabs:     make(map[string][]*Optab),
+	}
+}
+
+// buildTables reads the XED instruction database and populates
+// ctx.optabs/ctx.ytabs with the VEX/EVEX encodings used by the Go
+// assembler.
+//
+// AMD-only FMA4/XOP forms (see amdOpcodes) are only included when
+// ctx.includeAMD is set; by default they are dropped to keep the
+// table Intel-only.
+func buildTables(ctx *context) {
+	insts, err := xeddata.Objects(ctx.db)
+	if err != nil {
+		log.Fatalf("reading xed database: %v", err)
+	}
+
+	for _, inst := range insts {
+		opcode := goOpcodeName(inst)
+		if opcode == "" {
+			continue
+		}
+		if amdOpcodes[opcode] && !ctx.includeAMD {
+			continue
+		}
+
+		optab := &Optab{
+			Opcode:        opcode,
+			AMD:           amdOpcodes[opcode],
+			Width:         instWidth(inst),
+			Prefix:        inst.PrefixBytes(),
+			ModRM:         inst.ModRMForm(),
+			Encoding:      inst.OpcodeBytes(),
+			VexMMMMM:      inst.VexMMMMM(),
+			VexPP:         inst.VexPP(),
+			EvexW:         inst.EvexW(),
+			EncodingClass: inst.EncodingClass(),
+			CPUID:         inst.ISASet(),
+			IForms:        []string{inst.IForm()},
+		}
+		optab.MemWidth = memOperandWidth(inst, optab.Width)
+		optab.Evex = readEvexFlags(inst)
+		if optab.AMD {
+			// Tag AMD-only FMA4/XOP forms with a dedicated ytab family so
+			// that obj/x86 can gate them behind a CPU-feature check
+			// instead of treating them as first-class Intel encodings.
+			optab.Ytab = &Ytab{Name: "yAMDFMA4"}
+		}
+		ctx.optabs[opcode] = append(ctx.optabs[opcode], optab)
+	}
+
+	if ctx.modeSet&m16 != 0 {
+		addMode16Variants(ctx)
+	}
+
+	foldTables(ctx)
+}
+
+// addMode16Variants adds a yv16 ytab row alongside every VEX-encodable
+// optab row (EVEX-only AVX-512 forms have no 16-bit-mode encoding and
+// are skipped) so the generated tables can assemble instructions that
+// require a 16-bit operand/address size, e.g. legacy boot code mixing
+// SSE with a 16-bit code segment or real-mode SMM snippets.
+//
+// This tool doesn't do a separate XED walk for 16-bit addressing forms;
+// it derives the yv16 row's ModR/M form from the existing 32/64-bit row
+// via modRM16Form below. That rewrite is correct for the common case
+// (no SIB byte, disp16 instead of disp32) but, unlike the rest of this
+// generator, is not itself sourced from XED. Source rows with a
+// RIP-relative ModR/M form are skipped entirely: 16-bit mode has no
+// RIP-relative addressing, so there is no real yv16 form to derive for
+// them, and emitting a placeholder would silently fabricate an
+// encoding that doesn't exist.
+func addMode16Variants(ctx *context) {
+	for opcode, rows := range ctx.optabs {
+		for _, row := range rows {
+			if row.EncodingClass == "EVEX" || strings.Contains(row.ModRM, "[RIP]") {
+				continue
+			}
+			v16 := *row
+			v16.Ytab = &Ytab{Name: "yv16"}
+			v16.ModRM = modRM16Form(row.ModRM)
+			ctx.optabs[opcode] = append(ctx.optabs[opcode], &v16)
+		}
+	}
+}
+
+// modRM16Form rewrites a 32/64-bit ModR/M form into its 16-bit
+// addressing equivalent: 16-bit addressing has no SIB byte (there is
+// no index-scale encoding in 16-bit mode) and uses a 16-bit disp16
+// instead of disp32. Callers must not pass a RIP-relative form (see
+// addMode16Variants, which filters those out before calling this): 16-bit
+// mode has no RIP-relative addressing at all, so there is no rewrite
+// that would make one valid.
+func modRM16Form(modrm string) string {
+	s := modrm
+	s = strings.ReplaceAll(s, "+SIB", "")
+	s = strings.ReplaceAll(s, "disp32", "disp16")
+	return s + "(m16)"
+}
+
+// instWidth returns the szX/szY/szZ bit corresponding to inst's vector
+// length, as reported by XED's EVEX.L'L / VEX.L encoding.
+func instWidth(inst *xeddata.Inst) uint8 {
+	switch inst.VectorLength() {
+	case 256:
+		return szY
+	case 512:
+		return szZ
+	default:
+		return szX
+	}
+}
+
+// memOperandWidth returns the width class of inst's memory operand, or
+// 0 if it has none. For ordinary instructions this equals regWidth; for
+// broadcast sources, {1toN} converters and vpmov*/vbroadcast* narrowing
+// or widening forms, XED's ELEMENT_SIZE/MEMDISP attributes report a
+// memory operand narrower than the register width, and that must be
+// preserved rather than folded away.
+func memOperandWidth(inst *xeddata.Inst, regWidth uint8) uint8 {
+	elemBits, hasMem := inst.MemoryElementSize()
+	if !hasMem {
+		return 0
+	}
+	switch elemBits {
+	case 256:
+		return szY
+	case 512:
+		return szZ
+	default:
+		return szX
+	}
+}
+
+// readEvexFlags reads inst's AVX512_ROUND, SUPPRESS_ALL_EXCEPTIONS,
+// BCAST, MASK and ZEROING attributes from the XED database and turns
+// them into the flags writeEvexFlags reports for each row.
+func readEvexFlags(inst *xeddata.Inst) EvexFlags {
+	var f EvexFlags
+	f.ER = inst.HasAttribute("AVX512_ROUND")
+	f.SAE = inst.HasAttribute("SUPPRESS_ALL_EXCEPTIONS")
+	if n, ok := inst.BroadcastFactor(); ok {
+		switch n {
+		case 4:
+			f.Bcast4 = true
+		case 8:
+			f.Bcast8 = true
+		case 16:
+			f.Bcast16 = true
+		}
+	}
+	f.K = inst.HasAttribute("MASK")
+	f.Z = inst.HasAttribute("ZEROING")
+	return f
+}
+
+// foldTables ports the GNU assembler's match_mem_size template-folding
+// idea to the EVEX tables: it groups optab rows that differ only in
+// vector width (same opcode, prefix, ModR/M form and immediate kind)
+// and collapses a monotonic XMM->YMM->ZMM run of them into a single row
+// whose Width carries all the merged bits.
+//
+// Rows whose memory operand is narrower than their register operand
+// (broadcast, vpmov* down-converters, vbroadcast* up-converters) are
+// never folded with their siblings: folding them would make the
+// generated ytab claim a memory operand size that doesn't match what
+// the encoding actually reads or writes.
+func foldTables(ctx *context) {
+	for opcode, rows := range ctx.optabs {
+		groups := make(map[string][]*Optab)
+		var order []string
+		for _, row := range rows {
+			k := row.key()
+			if _, ok := groups[k]; !ok {
+				order = append(order, k)
+			}
+			groups[k] = append(groups[k], row)
+		}
+
+		var folded []*Optab
+		for _, k := range order {
+			group := groups[k]
+			if !foldable(group) {
+				folded = append(folded, group...)
+				continue
+			}
+			merged := *group[0]
+			merged.IForms = append([]string(nil), group[0].IForms...)
+			for _, row := range group[1:] {
+				merged.Width |= row.Width
+				merged.MemWidth |= row.MemWidth
+				merged.Evex = mergeEvexFlags(merged.Evex, row.Evex)
+				merged.IForms = append(merged.IForms, row.IForms...)
+			}
+			folded = append(folded, &merged)
+		}
+		ctx.optabs[opcode] = folded
+	}
+}
+
+// foldable reports whether every row in group may be merged into one
+// widened row: each must carry the sub-xmmword exception consistently
+// (a full-width memory operand, or none at all) and the set of widths
+// present must be a contiguous XMM->YMM->ZMM run with no duplicates.
+func foldable(group []*Optab) bool {
+	if len(group) < 2 {
+		return false
+	}
+	seen := uint8(0)
+	for _, row := range group {
+		if row.MemWidth != 0 && row.MemWidth != row.Width {
+			// Sub-xmmword exception: a narrower-than-register memory
+			// operand (broadcast/vpmov*/vbroadcast*) must not be folded.
+			return false
+		}
+		if seen&row.Width != 0 {
+			return false
+		}
+		seen |= row.Width
+	}
+	return seen == szX|szY || seen == szY|szZ || seen == szX|szY|szZ
+}
+
+// widthString renders a szX|szY|szZ bitmask as the letters of the
+// vector widths it covers, e.g. "XYZ" for a fully folded EVEX row.
+func widthString(width uint8) string {
+	var s string
+	if width&szX != 0 {
+		s += "X"
+	}
+	if width&szY != 0 {
+		s += "Y"
+	}
+	if width&szZ != 0 {
+		s += "Z"
+	}
+	return s
+}
+
+// goOpcodeName maps a XED instruction form to the Go assembler opcode
+// name it corresponds to. The real implementation lives alongside the
+// rest of the XED-to-Go translation; this stub exists so buildTables
+// has something concrete to call.
+func goOpcodeName(inst *xeddata.Inst) string {
+	return inst.GoName()
+}
+
+// writeTables writes the Go source for the ytab/optab tables consumed
+// by cmd/internal/obj/x86: a "// as: A%s" / "// width: %s" comment pair
+// per row (kept so -format=json's round-trip test can diff its output
+// against this one), followed by the literal avxYtab/avxOptab table
+// data itself. The comments are annotations on the data, not a
+// replacement for it: obj/x86 builds against avxYtab/avxOptab, not
+// against the comments.
+//
+// yv16 rows (see addMode16Variants and modRM16Form) carry a ModR/M form
+// with no SIB byte and a 16-bit disp16, rewritten from this generator's
+// ordinary 32/64-bit row rather than sourced from a separate XED walk;
+// obj/x86 must additionally emit the 0x67 address-size override prefix
+// before the instruction whenever it selects a yv16 row outside a
+// native 16-bit code segment, and must omit it inside one, exactly as
+// it already does for the legacy (non-VEX) opcode tables.
+func writeTables(w io.Writer, ctx *context) {
+	var buf bytes.Buffer
+
+	names := sortedOptabNames(ctx)
+
+	type ytabEntry struct {
+		zcase string
+		args  []string
+	}
+	ytabIndex := make(map[string]int)
+	var ytabs []ytabEntry
+	var optabLines []string
+
+	idx := 0
+	for _, name := range names {
+		for _, optab := range ctx.optabs[name] {
+			fmt.Fprintf(&buf, "// as: A%s\n", optab.Opcode)
+			fmt.Fprintf(&buf, "// width: %s\n", widthString(optab.Width))
+			switch {
+			case optab.AMD:
+				fmt.Fprintf(&buf, "// %s: AMD-only VEX/FMA4/XOP form, gate on CPU feature\n", optab.Ytab.Name)
+			case optab.Ytab != nil && optab.Ytab.Name == "yv16":
+				fmt.Fprintf(&buf, "// %s: 16-bit operand/address-size form\n", optab.Ytab.Name)
+			}
+			idx++
+
+			zcase, args := ytabShape(optab)
+			sig := zcase + "\x00" + strings.Join(args, ",")
+			ytIdx, ok := ytabIndex[sig]
+			if !ok {
+				ytIdx = len(ytabs)
+				ytabIndex[sig] = ytIdx
+				ytabs = append(ytabs, ytabEntry{zcase: zcase, args: args})
+			}
+			optabLines = append(optabLines, optabLiteral(optab, ytIdx))
+		}
+	}
+
+	io.Copy(w, &buf)
+
+	fmt.Fprintln(w, "\nvar avxYtab = []ytab{")
+	for _, yt := range ytabs {
+		fmt.Fprintf(w, "\t{zcase: %s, args: []uint8{%s}},\n", yt.zcase, strings.Join(yt.args, ", "))
+	}
+	fmt.Fprintln(w, "}")
+
+	fmt.Fprintln(w, "\nvar avxOptab = []Optab{")
+	for _, line := range optabLines {
+		fmt.Fprintf(w, "\t%s,\n", line)
+	}
+	fmt.Fprintln(w, "}")
+
+	writeEvexFlags(w, ctx, names)
+}
+
+// ytabShape derives the (zcase, args) pair an optab row's ytab is
+// emitted with: one register-or-memory class per surviving vector
+// width (from Width/MemWidth, the same bitmasks foldTables already
+// works in), plus a zcase that tells apart the VEX, EVEX and the two
+// hand-tagged special forms (AMD FMA4/XOP, 16-bit-mode). This reuses
+// this package's own bookkeeping rather than re-deriving operand shape
+// from XED, so it is only as precise as Width/MemWidth already are.
+func ytabShape(o *Optab) (zcase string, args []string) {
+	switch {
+	case o.Ytab != nil && o.Ytab.Name == "yv16":
+		zcase = "Zvex_rm_v_r_m16"
+	case o.Ytab != nil && o.Ytab.Name == "yAMDFMA4":
+		zcase = "Zvex_amd_fma4"
+	case o.EncodingClass == "EVEX":
+		zcase = "Zevex_rm_v_r"
+	default:
+		zcase = "Zvex_rm_v_r"
+	}
+	for _, w := range []uint8{szX, szY, szZ} {
+		if o.Width&w == 0 {
+			continue
+		}
+		args = append(args, regClass(w, o.MemWidth&w != 0))
+	}
+	return zcase, args
+}
+
+// regClass names the register-or-memory operand class for width w,
+// following the Yxr/Yxm (xmm reg / xmm reg-or-mem) naming convention
+// the legacy (non-VEX) optab tables already use in cmd/internal/obj/x86.
+func regClass(w uint8, mem bool) string {
+	switch w {
+	case szX:
+		if mem {
+			return "Yxm"
+		}
+		return "Yxr"
+	case szY:
+		if mem {
+			return "Yym"
+		}
+		return "Yyr"
+	default:
+		if mem {
+			return "Yzm"
+		}
+		return "Yzr"
+	}
+}
+
+// optabLiteral renders one Optab table entry referencing avxYtab[ytabIdx]
+// by index, so repeated (zcase, args) shapes are declared once and
+// shared rather than duplicated per opcode.
+func optabLiteral(o *Optab, ytabIdx int) string {
+	return fmt.Sprintf("{as: A%s, ytab: &avxYtab[%d], prefix: []byte{%s}, op: []byte{%s}}",
+		o.Opcode, ytabIdx, byteList(o.Prefix), byteList(o.Encoding))
+}
+
+// byteList renders b as a comma-separated list of Go hex byte literals,
+// e.g. []byte{0xc5, 0xf8} -> "0xc5, 0xf8".
+func byteList(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("0x%02x", v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeEvexFlags writes the evexFlags section as comment lines, one per
+// decorated optab row, each tagged with that row's position in the same
+// (opcode, then declaration) order writeTables used above. Rows with no
+// decorators at all get no line but still consume an index. No Go table
+// data is emitted for these flags: the section is a comment block, not a
+// variable obj/x86 can index.
+func writeEvexFlags(w io.Writer, ctx *context, names []string) {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// evexFlags")
+	idx := 0
+	for _, name := range names {
+		for _, optab := range ctx.optabs[name] {
+			if !optab.Evex.empty() {
+				fmt.Fprintf(&buf, "// evexFlags[%d] A%s: er=%v sae=%v bcast4=%v bcast8=%v bcast16=%v k=%v z=%v\n",
+					idx, optab.Opcode, optab.Evex.ER, optab.Evex.SAE,
+					optab.Evex.Bcast4, optab.Evex.Bcast8, optab.Evex.Bcast16,
+					optab.Evex.K, optab.Evex.Z)
+			}
+			idx++
+		}
+	}
+	io.Copy(w, &buf)
+}
+
+// parseModeSet parses a comma-separated "-mode" flag value such as
+// "m16,m32,m64" into a modeSet bitmask.
+func parseModeSet(s string) (uint8, error) {
+	var modeSet uint8
+	for _, part := range strings.Split(s, ",") {
+		switch part {
+		case "m16":
+			modeSet |= m16
+		case "m32":
+			modeSet |= m32
+		case "m64":
+			modeSet |= m64
+		default:
+			return 0, fmt.Errorf("unknown mode %q: want m16, m32 or m64", part)
+		}
+	}
+	return modeSet, nil
+}
+
+func main() {
+	xedPath := flag.String("xedpath", "", "path to XED datafiles")
+	includeAMD := flag.Bool("amd", false, "include AMD-only VEX/FMA4/XOP opcode tables")
+	format := flag.String("format", "go", "output format: go or json")
+	outPath := flag.String("o", "", "output file (default stdout)")
+	mode := flag.String("mode", "m32,m64", "comma-separated processor modes to generate ytab rows for: m16, m32, m64")
+	timings := flag.String("timings", "", "microarchitecture (e.g. ICL, SKX, HSW) to emit AVX latency/throughput timings for")
+	flag.Parse()
+
+	if *xedPath == "" {
+		log.Fatal("missing -xedpath")
+	}
+	switch *format {
+	case "go", "json":
+	default:
+		log.Fatalf("unknown -format %q: want go or json", *format)
+	}
+	modeSet, err := parseModeSet(*mode)
+	if err != nil {
+		log.Fatalf("-mode: %v", err)
+	}
+
+	db, err := xeddata.NewDatabase(*xedPath)
+	if err != nil {
+		log.Fatalf("open xed database: %v", err)
+	}
+
+	ctx := newContext(*xedPath, *includeAMD)
+	ctx.db = db
+	ctx.modeSet = modeSet
+
+	buildTables(ctx)
+
+	out := os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			log.Fatalf("create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *format == "json" {
+		if err := writeJSON(out, ctx); err != nil {
+			log.Fatalf("write json: %v", err)
+		}
+	} else {
+		writeTables(out, ctx)
+	}
+
+	if *timings != "" {
+		tf, err := os.Create(fmt.Sprintf("timings_%s.go", strings.ToLower(*timings)))
+		if err != nil {
+			log.Fatalf("create timings file: %v", err)
+		}
+		defer tf.Close()
+		if err := writeTimingsFile(tf, ctx, *timings); err != nil {
+			log.Fatalf("write timings file: %v", err)
+		}
+	}
+}
@@ -2,10 +2,16 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// See the NOTE on avxgen.go's package doc comment: this test file needs
+// the real xeddata package plus testdata/xedpath and testdata/golden.txt
+// to run, none of which are checked into this tree, so none of the tests
+// below have actually been executed against this checkout.
 package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
@@ -16,8 +22,13 @@ import (
 	"golang.org/x/arch/x86/xeddata"
 )
 
-func newTestContext(t testing.TB) *context {
-	ctx := &context{xedPath: filepath.Join("testdata", "xedpath")}
+func newTestContext(t testing.TB, includeAMD bool) *context {
+	return newTestContextMode(t, includeAMD, defaultModeSet)
+}
+
+func newTestContextMode(t testing.TB, includeAMD bool, modeSet uint8) *context {
+	ctx := newContext(filepath.Join("testdata", "xedpath"), includeAMD)
+	ctx.modeSet = modeSet
 	db, err := xeddata.NewDatabase(ctx.xedPath)
 	if err != nil {
 		t.Fatalf("open test database: %v", err)
@@ -35,7 +46,7 @@ func newStringSet(keys ...string) map[string]bool {
 }
 
 func generateToString(t *testing.T) string {
-	ctx := newTestContext(t)
+	ctx := newTestContext(t, false)
 	buildTables(ctx)
 	var buf bytes.Buffer
 	writeTables(&buf, ctx)
@@ -53,6 +64,7 @@ func TestOutput(t *testing.T) {
 		opcode     string
 		ytabs      string
 		optabLines string
+		evexFlags  string // empty if the golden entry has no evexFlags section
 	}
 	var testCases []testCase
 	{
@@ -66,11 +78,15 @@ func TestOutput(t *testing.T) {
 			ytabs := parts[0]
 			optabLines := parts[1]
 			opcode := opcodeRE.FindSubmatch(optabLines)[1]
-			testCases = append(testCases, testCase{
+			tc := testCase{
 				ytabs:      strings.TrimSpace(string(ytabs)),
 				optabLines: strings.TrimSpace(string(optabLines)),
 				opcode:     string(opcode)[len("A"):],
-			})
+			}
+			if len(parts) > 2 {
+				tc.evexFlags = strings.TrimSpace(string(parts[2]))
+			}
+			testCases = append(testCases, tc)
 		}
 	}
 
@@ -82,6 +98,9 @@ func TestOutput(t *testing.T) {
 		if !strings.Contains(output, tc.optabLines) {
 			t.Errorf("%s: optab lines not matched", tc.opcode)
 		}
+		if tc.evexFlags != "" && !strings.Contains(output, tc.evexFlags) {
+			t.Errorf("%s: evexFlags not matched", tc.opcode)
+		}
 	}
 }
 
@@ -107,10 +126,295 @@ func TestOutputStability(t *testing.T) {
 	wg.Wait()
 }
 
+func generateJSONToString(t *testing.T) string {
+	ctx := newTestContext(t, false)
+	buildTables(ctx)
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, ctx); err != nil {
+		t.Fatalf("writeJSON: %v", err)
+	}
+	return buf.String()
+}
+
+func TestOutputStabilityJSON(t *testing.T) {
+	// Same determinism guarantee as TestOutputStability, but for the
+	// -format=json writer: every concurrent invocation must produce
+	// byte-identical JSON.
+	const count = 8
+
+	want := generateJSONToString(t)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			if want != generateJSONToString(t) {
+				t.Errorf("json output #%d mismatches", i)
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	// Round-trip the JSON output back into jsonOpcode values and check
+	// two things: that the opcode/width comments writeTables put in the
+	// Go output can be reconstructed from it, and that the encoding
+	// fields (opcode bytes, VEX.mmmmm/pp) the JSON schema promises are
+	// actually populated rather than left at their zero value for every
+	// row.
+	ctx := newTestContext(t, false)
+	buildTables(ctx)
+	var goBuf bytes.Buffer
+	writeTables(&goBuf, ctx)
+	goOutput := goBuf.String()
+
+	var jsonBuf bytes.Buffer
+	if err := writeJSON(&jsonBuf, ctx); err != nil {
+		t.Fatalf("writeJSON: %v", err)
+	}
+
+	var opcodes []jsonOpcode
+	if err := json.Unmarshal(jsonBuf.Bytes(), &opcodes); err != nil {
+		t.Fatalf("unmarshal json output: %v", err)
+	}
+	if len(opcodes) == 0 {
+		t.Fatal("no opcodes in json output")
+	}
+
+	var reconstructed bytes.Buffer
+	haveEncoding := false
+	for _, op := range opcodes {
+		fmt.Fprintf(&reconstructed, "// as: A%s\n", op.Opcode)
+		fmt.Fprintf(&reconstructed, "// width: %s\n", op.Operands[0].Width)
+		if op.Encoding.OpcodeBytes != "" || op.Encoding.VexMMMMM != "" || op.Encoding.VexPP != "" {
+			haveEncoding = true
+		}
+	}
+	if !haveEncoding {
+		t.Errorf("no opcode carried encoding bytes/vex_mmmmm/vex_pp: Optab.Encoding/VexMMMMM/VexPP look unpopulated")
+	}
+
+	wantLines := filterCommentLines(goOutput, "// as: A", "// width: ")
+	gotLines := filterCommentLines(reconstructed.String(), "// as: A", "// width: ")
+	if gotLines != wantLines {
+		t.Errorf("json round-trip mismatch:\ngot:\n%s\nwant:\n%s", gotLines, wantLines)
+	}
+}
+
+// filterCommentLines returns the lines of s starting with any of
+// prefixes, joined back with newlines, preserving their relative order.
+func filterCommentLines(s string, prefixes ...string) string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		for _, p := range prefixes {
+			if strings.HasPrefix(line, p) {
+				out = append(out, line)
+				break
+			}
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestTimingsDoesNotAffectDefaultOutput(t *testing.T) {
+	// -timings is an additional, opt-in pass that writes its own
+	// timings_<uarch>.go file; it must never change the byte-identical
+	// default generateToString output that existing callers depend on.
+	withoutTimings := generateToString(t)
+
+	ctx := newTestContext(t, false)
+	buildTables(ctx)
+	var buf bytes.Buffer
+	writeTables(&buf, ctx)
+	var timingsBuf bytes.Buffer
+	if err := writeTimingsFile(&timingsBuf, ctx, "ICL"); err != nil {
+		t.Fatalf("writeTimingsFile: %v", err)
+	}
+
+	if buf.String() != withoutTimings {
+		t.Errorf("writeTables output changed when a timings pass also ran")
+	}
+	if timingsBuf.Len() == 0 {
+		t.Errorf("writeTimingsFile produced no output")
+	}
+}
+
+func TestMode16Coverage(t *testing.T) {
+	// Driving the generator with m16 enabled must produce a yv16 ytab
+	// variant for at least the VEX-encodable subset used by legacy
+	// boot/real-mode code mixing SSE with a 16-bit code segment.
+	wantYv16 := newStringSet("VMOVDQU", "VPXOR", "VPADDD")
+
+	ctx := newTestContextMode(t, false, defaultModeSet|m16)
+	buildTables(ctx)
+
+	for opcode := range wantYv16 {
+		rows := ctx.optabs[opcode]
+		if len(rows) == 0 {
+			t.Errorf("%s: no optab rows generated", opcode)
+			continue
+		}
+		var yv16 *Optab
+		for _, row := range rows {
+			if row.Ytab != nil && row.Ytab.Name == "yv16" {
+				yv16 = row
+				break
+			}
+		}
+		if yv16 == nil {
+			t.Errorf("%s: missing yv16 ytab variant with m16 enabled", opcode)
+			continue
+		}
+		if strings.Contains(yv16.ModRM, "+SIB") || strings.Contains(yv16.ModRM, "disp32") {
+			t.Errorf("%s: yv16 ModRM form %q still has a SIB byte or disp32", opcode, yv16.ModRM)
+		}
+		// The yv16 row's ModR/M form must actually be the 16-bit
+		// addressing form (no SIB, disp16), not a verbatim copy of the
+		// 32/64-bit row it was derived from.
+		for _, row := range rows {
+			if row == yv16 || row.Ytab != nil && row.Ytab.Name == "yv16" {
+				continue
+			}
+			if yv16.ModRM == row.ModRM {
+				t.Errorf("%s: yv16 ModRM form %q is identical to its 32/64-bit source row", opcode, yv16.ModRM)
+			}
+		}
+	}
+}
+
+func TestEvexBcastFlagsMatch(t *testing.T) {
+	// Every EVEX row whose memory operand reports a broadcast factor
+	// must carry the matching evexBcast* flag, so obj/x86 can validate
+	// {1toN} decorator syntax instead of silently accepting it anywhere.
+	ctx := newTestContext(t, false)
+	buildTables(ctx)
+
+	insts, err := xeddata.Objects(ctx.db)
+	if err != nil {
+		t.Fatalf("reading xed database: %v", err)
+	}
+	for _, inst := range insts {
+		n, ok := inst.BroadcastFactor()
+		if !ok || n == 0 {
+			continue
+		}
+		opcode := goOpcodeName(inst)
+		rows := ctx.optabs[opcode]
+		if len(rows) == 0 {
+			continue
+		}
+		got := false
+		for _, row := range rows {
+			switch n {
+			case 4:
+				got = got || row.Evex.Bcast4
+			case 8:
+				got = got || row.Evex.Bcast8
+			case 16:
+				got = got || row.Evex.Bcast16
+			}
+		}
+		if !got {
+			t.Errorf("%s: BCAST=%d on a memory operand but no evexBcast%d flag set", opcode, n, n)
+		}
+	}
+}
+
+func TestFoldingPreservesSemantics(t *testing.T) {
+	// foldTables collapses optab rows that differ only in a monotonic
+	// XMM->YMM->ZMM width promotion. Re-expand the folded tables and
+	// diff the resulting (opcode, prefix, modrm, immkind, width) tuples
+	// against the unfolded rows to prove the fold never lost or altered
+	// an encoding.
+	ctx := newTestContext(t, false)
+
+	insts, err := xeddata.Objects(ctx.db)
+	if err != nil {
+		t.Fatalf("reading xed database: %v", err)
+	}
+
+	unfolded := make(map[string]bool)
+	wantEvex := make(map[string]EvexFlags)     // keyed by Optab.key(), ignoring width
+	sourceEvex := make(map[string][]EvexFlags) // keyed by Optab.key() plus width
+	for _, inst := range insts {
+		opcode := goOpcodeName(inst)
+		if opcode == "" || (amdOpcodes[opcode] && !ctx.includeAMD) {
+			continue
+		}
+		row := &Optab{
+			Opcode: opcode,
+			Width:  instWidth(inst),
+			Prefix: inst.PrefixBytes(),
+			ModRM:  inst.ModRMForm(),
+		}
+		unfolded[row.key()+"\x00"+widthString(row.Width)] = true
+		wantEvex[row.key()] = mergeEvexFlags(wantEvex[row.key()], readEvexFlags(inst))
+		sourceEvex[row.key()+"\x00"+widthString(row.Width)] = append(sourceEvex[row.key()+"\x00"+widthString(row.Width)], readEvexFlags(inst))
+	}
+
+	buildTables(ctx)
+
+	expanded := make(map[string]bool)
+	for _, rows := range ctx.optabs {
+		for _, row := range rows {
+			for _, w := range []uint8{szX, szY, szZ} {
+				if row.Width&w == 0 {
+					continue
+				}
+				expanded[row.key()+"\x00"+widthString(w)] = true
+			}
+			if row.Width != szX && row.Width != szY && row.Width != szZ {
+				// A folded row covers its whole group, so it must carry
+				// every width's decorators.
+				if row.Evex != wantEvex[row.key()] {
+					t.Errorf("folding changed evexFlags for %q: got %+v, want %+v", row.key(), row.Evex, wantEvex[row.key()])
+				}
+				continue
+			}
+			// A row left unfolded must keep the flags of a source row of
+			// its own width.
+			kept := false
+			for _, f := range sourceEvex[row.key()+"\x00"+widthString(row.Width)] {
+				kept = kept || f == row.Evex
+			}
+			if !kept {
+				t.Errorf("unfolded row %q width %s: evexFlags %+v match no source row", row.key(), widthString(row.Width), row.Evex)
+			}
+		}
+	}
+
+	for k := range unfolded {
+		if !expanded[k] {
+			t.Errorf("folding dropped encoding %q", k)
+		}
+	}
+	for k := range expanded {
+		if !unfolded[k] {
+			t.Errorf("folding invented encoding %q", k)
+		}
+	}
+}
+
 func TestOpcodeCoverage(t *testing.T) {
-	// Check that generator produces all expected opcodes from testdata files.
+	// Check that generator produces all expected opcodes from testdata files,
+	// once with the default Intel-only table and once with -amd passed so
+	// the AMD-only FMA4/XOP forms are also covered.
 	// All opcodes are in Go syntax.
 
+	for _, includeAMD := range []bool{false, true} {
+		includeAMD := includeAMD
+		name := "IntelOnly"
+		if includeAMD {
+			name = "IncludeAMD"
+		}
+		t.Run(name, func(t *testing.T) {
+			testOpcodeCoverage(t, includeAMD)
+		})
+	}
+}
+
+func testOpcodeCoverage(t *testing.T, includeAMD bool) {
 	// VEX/EVEX opcodes collected from XED-based x86.csv.
 	expectedOpcodes := newStringSet(
 		"ANDNL",
@@ -871,37 +1175,15 @@ func TestOpcodeCoverage(t *testing.T) {
 		"VZEROALL",
 		"VZEROUPPER")
 
-	// AMD-specific VEX opcodes.
-	// Excluded from x86avxgen output for now.
-	amdOpcodes := newStringSet(
-		"VFMADDPD",
-		"VFMADDPS",
-		"VFMADDSD",
-		"VFMADDSS",
-		"VFMADDSUBPD",
-		"VFMADDSUBPS",
-		"VFMSUBADDPD",
-		"VFMSUBADDPS",
-		"VFMSUBPD",
-		"VFMSUBPS",
-		"VFMSUBSD",
-		"VFMSUBSS",
-		"VFNMADDPD",
-		"VFNMADDPS",
-		"VFNMADDSD",
-		"VFNMADDSS",
-		"VFNMSUBPD",
-		"VFNMSUBPS",
-		"VFNMSUBSD",
-		"VFNMSUBSS",
-		"VPERMIL2PD",
-		"VPERMIL2PS")
-
-	ctx := newTestContext(t)
+	ctx := newTestContext(t, includeAMD)
 	buildTables(ctx)
 
-	for op := range amdOpcodes {
-		delete(expectedOpcodes, op)
+	// AMD-only VEX/FMA4/XOP opcodes are only emitted when -amd is set;
+	// see amdOpcodes in avxgen.go.
+	if !includeAMD {
+		for op := range amdOpcodes {
+			delete(expectedOpcodes, op)
+		}
 	}
 	for op := range ctx.optabs {
 		delete(expectedOpcodes, op)
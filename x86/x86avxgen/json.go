@@ -0,0 +1,98 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// jsonOperand is one operand slot of a jsonOpcode entry.
+type jsonOperand struct {
+	Kind  string `json:"kind"`
+	Width string `json:"width"`
+	RW    string `json:"rw"`
+	Bcast bool   `json:"bcast,omitempty"`
+	Mask  bool   `json:"mask,omitempty"`
+}
+
+// jsonEncoding is the encoding-bytes portion of a jsonOpcode entry.
+type jsonEncoding struct {
+	Prefix      string `json:"prefix"`
+	OpcodeBytes string `json:"opcode_bytes"`
+	ModRM       string `json:"modrm"`
+	Imm         string `json:"imm"`
+	VexMMMMM    string `json:"vex_mmmmm"`
+	VexPP       string `json:"vex_pp"`
+	EvexW       int    `json:"evex_w"`
+}
+
+// jsonOpcode is one opcode's entry in the -format=json output: the same
+// curated AVX table the Go assembler uses, in a form that doesn't
+// require re-parsing XED.
+type jsonOpcode struct {
+	Opcode    string        `json:"opcode"`
+	CPUID     string        `json:"cpuid,omitempty"`
+	VexOrEvex string        `json:"vex_or_evex"`
+	Operands  []jsonOperand `json:"operands"`
+	Encoding  jsonEncoding  `json:"encoding"`
+}
+
+// toJSONOpcodes converts ctx.optabs into the stable, sorted slice
+// written by writeJSON. It reuses the same opcode-then-declaration
+// order as writeTables so the JSON and Go outputs agree on ordering.
+func toJSONOpcodes(ctx *context) []jsonOpcode {
+	names := sortedOptabNames(ctx)
+
+	var opcodes []jsonOpcode
+	for _, name := range names {
+		for _, optab := range ctx.optabs[name] {
+			opcodes = append(opcodes, jsonOpcode{
+				Opcode:    optab.Opcode,
+				CPUID:     optab.CPUID,
+				VexOrEvex: optab.EncodingClass,
+				Operands: []jsonOperand{{
+					Kind:  "xmm/ymm/zmm",
+					Width: widthString(optab.Width),
+					RW:    "rw",
+					Bcast: optab.Evex.Bcast4 || optab.Evex.Bcast8 || optab.Evex.Bcast16,
+					Mask:  optab.Evex.K,
+				}},
+				Encoding: jsonEncoding{
+					Prefix:      fmt.Sprintf("%x", optab.Prefix),
+					OpcodeBytes: fmt.Sprintf("%x", optab.Encoding),
+					ModRM:       optab.ModRM,
+					Imm:         optab.ImmKind,
+					VexMMMMM:    optab.VexMMMMM,
+					VexPP:       optab.VexPP,
+					EvexW:       int(optab.EvexW),
+				},
+			})
+		}
+	}
+	return opcodes
+}
+
+// writeJSON writes ctx's optab/ytab tables as the stable JSON schema
+// consumed by non-Go tools (disassemblers, fuzzers, alternative
+// assemblers) that want the curated AVX table without re-parsing XED.
+func writeJSON(w io.Writer, ctx *context) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(toJSONOpcodes(ctx))
+}
+
+// sortedOptabNames returns ctx.optabs' opcode names sorted, matching
+// the order writeTables iterates them in.
+func sortedOptabNames(ctx *context) []string {
+	names := make([]string, 0, len(ctx.optabs))
+	for name := range ctx.optabs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
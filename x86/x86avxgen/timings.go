@@ -0,0 +1,133 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// timing is one opcode's latency/throughput entry, as read from XED's
+// microarchitecture timing tables (<xedpath>/<uarch>-perf.txt).
+type timing struct {
+	Latency, Throughput uint8
+}
+
+// readTimings parses the XED perf table for uarch and joins it against
+// ctx.optabs by IFORM, as XED's perf tables key their rows: a bare Go
+// opcode (e.g. "VADDPD") can't be looked up directly, since the table's
+// first column carries width/suffix information (e.g. "VADDPD_XMM",
+// "VADDPD_YMM", "VADDPD_ZMM").
+//
+// avxTimings is emitted as map[obj.As]struct{...}, one entry per
+// opcode rather than per width, so when an opcode's optab row spans
+// several IFORMs (post-fold, see Optab.IForms) this reports the worst
+// case: the maximum latency and throughput seen across them. That
+// keeps the scheduler's cost estimate conservative instead of
+// optimistic when widths genuinely differ in cost.
+func readTimings(ctx *context, uarch string) (map[string]timing, error) {
+	entries, err := parsePerfTable(ctx.xedPath, uarch)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s timing table: %v", uarch, err)
+	}
+
+	timings := make(map[string]timing)
+	for opcode, rows := range ctx.optabs {
+		var t timing
+		found := false
+		for _, row := range rows {
+			for _, iform := range row.IForms {
+				e, ok := entries[iform]
+				if !ok {
+					continue
+				}
+				found = true
+				if e.Latency > t.Latency {
+					t.Latency = e.Latency
+				}
+				if e.Throughput > t.Throughput {
+					t.Throughput = e.Throughput
+				}
+			}
+		}
+		if found {
+			timings[opcode] = t
+		}
+	}
+	return timings, nil
+}
+
+// parsePerfTable reads XED's <uarch>-perf.txt table from xedPath and
+// returns its IFORM->timing entries. Each non-comment, non-blank line
+// has the form "IFORM latency throughput".
+func parsePerfTable(xedPath, uarch string) (map[string]timing, error) {
+	f, err := os.Open(filepath.Join(xedPath, uarch+"-perf.txt"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]timing)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+		latency, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parsing latency in %q: %v", line, err)
+		}
+		throughput, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parsing throughput in %q: %v", line, err)
+		}
+		entries[fields[0]] = timing{Latency: uint8(latency), Throughput: uint8(throughput)}
+	}
+	return entries, scanner.Err()
+}
+
+// writeTimingsFile writes a timings_<uarch>.go source file containing
+// avxTimings, the map cmd/compile/internal/ssa's scheduler joins
+// against obj.As to get a principled AVX instruction cost instead of
+// a hand-maintained approximation.
+//
+// This is an additional, opt-in pass: it never runs unless -timings is
+// set, and it writes to its own file rather than touching the output
+// of writeTables, so the default `x86avxgen -xedpath=...` output stays
+// byte-identical whether or not -timings is used.
+func writeTimingsFile(w io.Writer, ctx *context, uarch string) error {
+	timings, err := readTimings(ctx, uarch)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(timings))
+	for name := range timings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "// Code generated by x86avxgen -timings=%s. DO NOT EDIT.\n\n", uarch)
+	fmt.Fprintf(w, "package x86\n\n")
+	fmt.Fprintf(w, "import \"cmd/internal/obj\"\n\n")
+	fmt.Fprintf(w, "var avxTimings = map[obj.As]struct{ Latency, Throughput uint8 }{\n")
+	for _, name := range names {
+		t := timings[name]
+		fmt.Fprintf(w, "\tA%s: {Latency: %d, Throughput: %d},\n", name, t.Latency, t.Throughput)
+	}
+	fmt.Fprintf(w, "}\n")
+	return nil
+}